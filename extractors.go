@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"golang.org/x/net/html"
+	"strings"
+)
+
+// Record is the structured result of running the extractor pipeline
+// against a single fetched page. Extractors only ever add information:
+// a field left zero-valued means no registered extractor could fill it.
+type Record struct {
+	Url         string                   `json:"url"`
+	Categories  []string                 `json:"categories,omitempty"`
+	Title       string                   `json:"title,omitempty"`
+	Description string                   `json:"description,omitempty"`
+	Image       string                   `json:"image,omitempty"`
+	SiteName    string                   `json:"site_name,omitempty"`
+	Canonical   string                   `json:"canonical,omitempty"`
+	Hreflang    map[string]string        `json:"hreflang,omitempty"`
+	Schema      []map[string]interface{} `json:"schema,omitempty"`
+	Summary     string                   `json:"summary,omitempty"`
+}
+
+// ExtractionContext carries everything an Extractor needs. The body is
+// kept around as raw bytes so each extractor can run its own tokenizer
+// over it independently instead of fighting over a single stream.
+type ExtractionContext struct {
+	Url  string
+	Body []byte
+}
+
+func (c *ExtractionContext) tokenizer() *html.Tokenizer {
+	return html.NewTokenizer(bytes.NewReader(c.Body))
+}
+
+// Extractor fills in whatever fields of a Record it knows how to
+// produce. It must not overwrite a field another, higher-priority
+// extractor has already set.
+type Extractor interface {
+	// Fields lists the Record fields this extractor is able to fill.
+	Fields() []string
+	// Extract runs over ctx and fills empty fields on rec.
+	Extract(ctx *ExtractionContext, rec *Record)
+}
+
+// extractors is the built-in pipeline, ordered from richest to
+// fallback. Earlier extractors win: a later one only fills a field
+// that is still at its zero value.
+var extractors = []Extractor{
+	jsonLDExtractor{},
+	openGraphExtractor{},
+	twitterCardExtractor{},
+	canonicalExtractor{},
+	readabilityExtractor{},
+	titleDescriptionExtractor{},
+}
+
+// runExtractors executes the pipeline in priority order and returns the
+// filled Record.
+func runExtractors(ctx *ExtractionContext) *Record {
+	rec := &Record{Url: ctx.Url}
+	for _, e := range extractors {
+		e.Extract(ctx, rec)
+	}
+	return rec
+}
+
+func attr(tkn html.Token, key string) (string, bool) {
+	for _, a := range tkn.Attr {
+		if a.Key == key {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+// walkMeta walks every <meta> tag in the document and calls fn with its
+// token, so property-based (OpenGraph) and name-based (Twitter Card,
+// description) extractors can share the same scan.
+func walkMeta(ctx *ExtractionContext, fn func(tkn html.Token)) {
+	tokens := ctx.tokenizer()
+	for {
+		tt := tokens.Next()
+		if tt == html.ErrorToken {
+			return
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+		tkn := tokens.Token()
+		if tkn.Data == "meta" {
+			fn(tkn)
+		}
+	}
+}
+
+// openGraphExtractor fills Title/Description/Image/SiteName from
+// <meta property="og:*"> tags.
+type openGraphExtractor struct{}
+
+func (openGraphExtractor) Fields() []string {
+	return []string{"Title", "Description", "Image", "SiteName"}
+}
+
+func (openGraphExtractor) Extract(ctx *ExtractionContext, rec *Record) {
+	walkMeta(ctx, func(tkn html.Token) {
+		prop, ok := attr(tkn, "property")
+		if !ok {
+			return
+		}
+		content, ok := attr(tkn, "content")
+		if !ok {
+			return
+		}
+		content = strings.TrimSpace(regexSpace.ReplaceAllString(content, " "))
+		switch strings.ToLower(prop) {
+		case "og:title":
+			if rec.Title == "" {
+				rec.Title = content
+			}
+		case "og:description":
+			if rec.Description == "" {
+				rec.Description = content
+			}
+		case "og:image":
+			if rec.Image == "" {
+				rec.Image = content
+			}
+		case "og:site_name":
+			if rec.SiteName == "" {
+				rec.SiteName = content
+			}
+		}
+	})
+}
+
+// twitterCardExtractor fills Title/Description/Image from
+// <meta name="twitter:*"> tags, used when a page has no OpenGraph tags.
+type twitterCardExtractor struct{}
+
+func (twitterCardExtractor) Fields() []string {
+	return []string{"Title", "Description", "Image"}
+}
+
+func (twitterCardExtractor) Extract(ctx *ExtractionContext, rec *Record) {
+	walkMeta(ctx, func(tkn html.Token) {
+		name, ok := attr(tkn, "name")
+		if !ok {
+			return
+		}
+		content, ok := attr(tkn, "content")
+		if !ok {
+			return
+		}
+		content = strings.TrimSpace(regexSpace.ReplaceAllString(content, " "))
+		switch strings.ToLower(name) {
+		case "twitter:title":
+			if rec.Title == "" {
+				rec.Title = content
+			}
+		case "twitter:description":
+			if rec.Description == "" {
+				rec.Description = content
+			}
+		case "twitter:image":
+			if rec.Image == "" {
+				rec.Image = content
+			}
+		}
+	})
+}
+
+// canonicalExtractor fills Canonical and Hreflang from <link> tags.
+type canonicalExtractor struct{}
+
+func (canonicalExtractor) Fields() []string {
+	return []string{"Canonical", "Hreflang"}
+}
+
+func (canonicalExtractor) Extract(ctx *ExtractionContext, rec *Record) {
+	tokens := ctx.tokenizer()
+	for {
+		tt := tokens.Next()
+		if tt == html.ErrorToken {
+			return
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+		tkn := tokens.Token()
+		if tkn.Data != "link" {
+			continue
+		}
+		rel, _ := attr(tkn, "rel")
+		href, ok := attr(tkn, "href")
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(rel) {
+		case "canonical":
+			if rec.Canonical == "" {
+				rec.Canonical = href
+			}
+		case "alternate":
+			if lang, ok := attr(tkn, "hreflang"); ok {
+				if rec.Hreflang == nil {
+					rec.Hreflang = make(map[string]string)
+				}
+				if _, ok := rec.Hreflang[lang]; !ok {
+					rec.Hreflang[lang] = href
+				}
+			}
+		}
+	}
+}
+
+// jsonLDExtractor parses <script type="application/ld+json"> blocks and
+// fills Title/Description/Image from schema.org Article or Product
+// objects, keeping the raw decoded objects on rec.Schema regardless of
+// type so callers can inspect BreadcrumbList and anything else.
+type jsonLDExtractor struct{}
+
+func (jsonLDExtractor) Fields() []string {
+	return []string{"Title", "Description", "Image", "Schema"}
+}
+
+func (jsonLDExtractor) Extract(ctx *ExtractionContext, rec *Record) {
+	tokens := ctx.tokenizer()
+	inScript := false
+	for {
+		tt := tokens.Next()
+		if tt == html.ErrorToken {
+			return
+		}
+		switch tt {
+		case html.StartTagToken:
+			tkn := tokens.Token()
+			if tkn.Data != "script" {
+				continue
+			}
+			typ, _ := attr(tkn, "type")
+			inScript = strings.EqualFold(typ, "application/ld+json")
+		case html.TextToken:
+			if !inScript {
+				continue
+			}
+			inScript = false
+			jsonLDExtractor{}.apply(tokens.Token().Data, rec)
+		}
+	}
+}
+
+func (jsonLDExtractor) apply(raw string, rec *Record) {
+	var obj map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &obj); err != nil {
+		return
+	}
+	rec.Schema = append(rec.Schema, obj)
+
+	typ, _ := obj["@type"].(string)
+	switch typ {
+	case "Article", "NewsArticle", "BlogPosting":
+		if rec.Title == "" {
+			if v, ok := obj["headline"].(string); ok {
+				rec.Title = v
+			}
+		}
+		if rec.Description == "" {
+			if v, ok := obj["description"].(string); ok {
+				rec.Description = v
+			}
+		}
+		if rec.Image == "" {
+			rec.Image = jsonLDImage(obj["image"])
+		}
+	case "Product":
+		if rec.Title == "" {
+			if v, ok := obj["name"].(string); ok {
+				rec.Title = v
+			}
+		}
+		if rec.Description == "" {
+			if v, ok := obj["description"].(string); ok {
+				rec.Description = v
+			}
+		}
+		if rec.Image == "" {
+			rec.Image = jsonLDImage(obj["image"])
+		}
+	}
+}
+
+// jsonLDImage normalizes the "image" property of a schema.org object,
+// which may be a plain string, an ImageObject, or an array of either.
+func jsonLDImage(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case map[string]interface{}:
+		if u, ok := val["url"].(string); ok {
+			return u
+		}
+	case []interface{}:
+		if len(val) > 0 {
+			return jsonLDImage(val[0])
+		}
+	}
+	return ""
+}
+
+// titleDescriptionExtractor is the original, lowest-priority fallback:
+// the plain <title> and <meta name="description"> tags.
+type titleDescriptionExtractor struct{}
+
+func (titleDescriptionExtractor) Fields() []string {
+	return []string{"Title", "Description"}
+}
+
+func (titleDescriptionExtractor) Extract(ctx *ExtractionContext, rec *Record) {
+	tokens := ctx.tokenizer()
+	for {
+		tt := tokens.Next()
+		if tt == html.ErrorToken {
+			return
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+		tkn := tokens.Token()
+		switch tkn.Data {
+		case "title":
+			if rec.Title != "" || tt != html.StartTagToken {
+				continue
+			}
+			if tokens.Next() == html.TextToken {
+				rec.Title = strings.TrimSpace(regexSpace.ReplaceAllString(tokens.Token().Data, " "))
+			}
+		case "meta":
+			if rec.Description != "" {
+				continue
+			}
+			name, ok := attr(tkn, "name")
+			if !ok || strings.ToLower(name) != "description" {
+				continue
+			}
+			if content, ok := attr(tkn, "content"); ok {
+				rec.Description = strings.TrimSpace(regexSpace.ReplaceAllString(content, " "))
+			}
+		}
+	}
+}
+
+// readabilityExtractor is the last resort: when no structured source
+// gave us a description, it walks the document scoring <p>/<article>
+// blocks by text density and emits a short plain-text Summary from the
+// highest-scoring block.
+type readabilityExtractor struct{}
+
+func (readabilityExtractor) Fields() []string {
+	return []string{"Summary"}
+}
+
+func (readabilityExtractor) Extract(ctx *ExtractionContext, rec *Record) {
+	tokens := ctx.tokenizer()
+
+	type block struct {
+		text  strings.Builder
+		score int
+	}
+	var blocks []*block
+	var cur *block
+	depth := 0
+
+	for {
+		tt := tokens.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		switch tt {
+		case html.StartTagToken:
+			tkn := tokens.Token()
+			if tkn.Data == "p" || tkn.Data == "article" {
+				cur = &block{}
+				if tkn.Data == "article" {
+					cur.score += 25
+				}
+				blocks = append(blocks, cur)
+				depth++
+			}
+		case html.EndTagToken:
+			tkn := tokens.Token()
+			if (tkn.Data == "p" || tkn.Data == "article") && depth > 0 {
+				depth--
+				cur = nil
+			}
+		case html.TextToken:
+			if cur == nil {
+				continue
+			}
+			text := strings.TrimSpace(tokens.Token().Data)
+			if text == "" {
+				continue
+			}
+			cur.text.WriteString(text)
+			cur.text.WriteString(" ")
+			cur.score += len(text)
+		}
+	}
+
+	var best *block
+	for _, b := range blocks {
+		if best == nil || b.score > best.score {
+			best = b
+		}
+	}
+	if best == nil {
+		return
+	}
+
+	summary := strings.TrimSpace(regexSpace.ReplaceAllString(best.text.String(), " "))
+	const maxSummaryLen = 500
+	if len(summary) > maxSummaryLen {
+		summary = summary[:maxSummaryLen]
+	}
+	rec.Summary = summary
+}
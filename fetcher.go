@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxBackoff caps the exponential backoff applied between retries of a
+// single URL, regardless of how many attempts have been made.
+const maxBackoff = 30 * time.Second
+
+// hostState tracks the per-host throttle and cached robots.txt rules
+// that a Fetcher needs to stay polite to one particular host.
+type hostState struct {
+	mu     sync.Mutex
+	next   time.Time
+	robots *robotsRules
+}
+
+// Fetcher wraps an http.Client with per-host rate limiting, a robots.txt
+// check and retry-with-backoff, so worker no longer has to fire
+// requests at a host as fast as the channel delivers them.
+type Fetcher struct {
+	cli           *http.Client
+	userAgent     string
+	minDelay      time.Duration
+	maxRetries    int
+	respectRobots bool
+
+	mu    sync.Mutex
+	hosts map[string]*hostState
+}
+
+// newFetcher builds a Fetcher. qps <= 0 disables per-host throttling.
+func newFetcher(qps float64, maxRetries int, userAgent string, respectRobots bool) *Fetcher {
+	var minDelay time.Duration
+	if qps > 0 {
+		minDelay = time.Duration(float64(time.Second) / qps)
+	}
+	return &Fetcher{
+		cli:           &http.Client{Timeout: 15 * time.Second},
+		userAgent:     userAgent,
+		minDelay:      minDelay,
+		maxRetries:    maxRetries,
+		respectRobots: respectRobots,
+		hosts:         make(map[string]*hostState),
+	}
+}
+
+func (f *Fetcher) stateFor(host string) *hostState {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	hs, ok := f.hosts[host]
+	if !ok {
+		hs = &hostState{}
+		f.hosts[host] = hs
+	}
+	return hs
+}
+
+// Get fetches rawURL, waiting out this host's rate limit, skipping it
+// if robots.txt disallows it, and retrying 5xx/network errors with
+// exponential backoff and jitter.
+func (f *Fetcher) Get(rawURL string) (*http.Response, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	hs := f.stateFor(u.Host)
+
+	if f.respectRobots {
+		robots := f.robotsFor(hs, u.Scheme, u.Host)
+		if !robots.allows(u.Path) {
+			return nil, fmt.Errorf("%s disallowed by robots.txt", rawURL)
+		}
+	}
+
+	f.throttle(hs)
+
+	delay := time.Second
+	var lastErr error
+	for attempt := 0; attempt <= f.maxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(delay + jitter(delay))
+			delay *= 2
+			if delay > maxBackoff {
+				delay = maxBackoff
+			}
+		}
+
+		req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("User-Agent", f.userAgent)
+
+		resp, err := f.cli.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode >= 500 {
+			resp.Body.Close()
+			lastErr = fmt.Errorf("%s returned status %d", rawURL, resp.StatusCode)
+			continue
+		}
+		return resp, nil
+	}
+	return nil, lastErr
+}
+
+func (f *Fetcher) throttle(hs *hostState) {
+	if f.minDelay == 0 {
+		return
+	}
+
+	hs.mu.Lock()
+	now := time.Now()
+	wait := hs.next.Sub(now)
+	if wait < 0 {
+		wait = 0
+	}
+	hs.next = now.Add(wait).Add(f.minDelay)
+	hs.mu.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// robotsFor caches robots.txt per host, fetched over whichever scheme
+// the first request to that host used.
+func (f *Fetcher) robotsFor(hs *hostState, scheme, host string) *robotsRules {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	if hs.robots == nil {
+		hs.robots = fetchRobots(f.cli, scheme, host, f.userAgent)
+	}
+	return hs.robots
+}
+
+// jitter returns a random duration in [0, d/2), used to spread out
+// retries instead of every worker waking up in lockstep.
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)/2 + 1))
+}
+
+// robotsRule is a single Allow or Disallow prefix gathered from a group
+// that matches our user agent.
+type robotsRule struct {
+	prefix string
+	allow  bool
+}
+
+// robotsRules is a minimal robots.txt representation: the Allow and
+// Disallow prefixes that apply to us, gathered from the groups that
+// match our user agent (or "*" when none do). As in the real spec, the
+// longest matching prefix wins regardless of which directive it came
+// from.
+type robotsRules struct {
+	rules []robotsRule
+}
+
+func (r *robotsRules) allows(path string) bool {
+	if r == nil {
+		return true
+	}
+	bestLen := -1
+	bestAllow := true
+	for _, rule := range r.rules {
+		if !strings.HasPrefix(path, rule.prefix) {
+			continue
+		}
+		// On a tie, Allow wins regardless of which one appeared first in
+		// the file.
+		if len(rule.prefix) > bestLen || (len(rule.prefix) == bestLen && rule.allow) {
+			bestLen = len(rule.prefix)
+			bestAllow = rule.allow
+		}
+	}
+	return bestAllow
+}
+
+// fetchRobots downloads and parses /robots.txt for host over scheme
+// (the scheme of the URL that triggered the fetch, so an https-only
+// site isn't silently treated as having no robots.txt at all). Any
+// failure to fetch or parse it is treated as "no rules", matching how
+// browsers and most crawlers degrade when a site has no robots.txt.
+func fetchRobots(cli *http.Client, scheme, host, userAgent string) *robotsRules {
+	if scheme == "" {
+		scheme = "http"
+	}
+	resp, err := cli.Get(scheme + "://" + host + "/robots.txt")
+	if err != nil {
+		return &robotsRules{}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return &robotsRules{}
+	}
+	return parseRobots(resp.Body, userAgent)
+}
+
+func parseRobots(body io.Reader, userAgent string) *robotsRules {
+	scanner := bufio.NewScanner(body)
+	rules := &robotsRules{}
+
+	matchesUs := false
+	groupHasUs := false
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, val, ok := splitRobotsLine(line)
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(key) {
+		case "user-agent":
+			if !groupHasUs {
+				matchesUs = false
+			}
+			if val == "*" || strings.Contains(strings.ToLower(userAgent), strings.ToLower(val)) {
+				matchesUs = true
+				groupHasUs = true
+			}
+		case "disallow":
+			if matchesUs && val != "" {
+				rules.rules = append(rules.rules, robotsRule{prefix: val, allow: false})
+			}
+			groupHasUs = false
+		case "allow":
+			if matchesUs && val != "" {
+				rules.rules = append(rules.rules, robotsRule{prefix: val, allow: true})
+			}
+			groupHasUs = false
+		}
+	}
+	return rules
+}
+
+func splitRobotsLine(line string) (key, val string, ok bool) {
+	idx := strings.Index(line, ":")
+	if idx < 0 {
+		return "", "", false
+	}
+	return strings.TrimSpace(line[:idx]), strings.TrimSpace(line[idx+1:]), true
+}
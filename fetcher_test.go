@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRobotsRulesAllows(t *testing.T) {
+	tests := []struct {
+		name    string
+		robots  string
+		path    string
+		allowed bool
+	}{
+		{
+			name:    "no rules for us",
+			robots:  "User-agent: *\n",
+			path:    "/anything",
+			allowed: true,
+		},
+		{
+			name:    "plain disallow",
+			robots:  "User-agent: *\nDisallow: /private\n",
+			path:    "/private/secret",
+			allowed: false,
+		},
+		{
+			name:    "allow overrides a shorter disallow",
+			robots:  "User-agent: *\nDisallow: /private\nAllow: /private/ok\n",
+			path:    "/private/ok.html",
+			allowed: true,
+		},
+		{
+			name:    "disallow still applies outside the allowed prefix",
+			robots:  "User-agent: *\nDisallow: /private\nAllow: /private/ok\n",
+			path:    "/private/secret.html",
+			allowed: false,
+		},
+		{
+			name:    "longer disallow wins over a shorter allow",
+			robots:  "User-agent: *\nAllow: /private\nDisallow: /private/secret\n",
+			path:    "/private/secret.html",
+			allowed: false,
+		},
+		{
+			name:    "empty disallow means no restriction",
+			robots:  "User-agent: *\nDisallow:\n",
+			path:    "/anything",
+			allowed: true,
+		},
+		{
+			name:    "rules for another user agent don't apply",
+			robots:  "User-agent: other-bot\nDisallow: /private\n",
+			path:    "/private",
+			allowed: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := parseRobots(strings.NewReader(tt.robots), "sites_parsing_tool")
+			if got := rules.allows(tt.path); got != tt.allowed {
+				t.Errorf("allows(%q) = %v, want %v", tt.path, got, tt.allowed)
+			}
+		})
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Decoder turns an input stream into a sequence of items, handed to fn
+// one at a time so a format that can stream (jsonl) doesn't have to
+// buffer the whole input just because another format (sitemap) must.
+type Decoder interface {
+	Decode(r io.Reader, fn func(*item)) error
+}
+
+// decoderFor picks a Decoder for arg, preferring an explicit
+// -input-format flag over guessing from the file extension.
+func decoderFor(format, arg string) (Decoder, error) {
+	if format != "" {
+		return decoderByName(format)
+	}
+
+	lower := strings.ToLower(arg)
+	switch {
+	case strings.HasSuffix(lower, ".xml"), strings.HasSuffix(lower, ".xml.gz"):
+		return sitemapDecoder{}, nil
+	case strings.HasSuffix(lower, ".rss"), strings.HasSuffix(lower, ".atom"):
+		return feedDecoder{}, nil
+	case strings.HasSuffix(lower, ".csv"):
+		return csvDecoder{}, nil
+	default:
+		return jsonlDecoder{}, nil
+	}
+}
+
+func decoderByName(format string) (Decoder, error) {
+	switch format {
+	case "jsonl", "":
+		return jsonlDecoder{}, nil
+	case "sitemap":
+		return sitemapDecoder{}, nil
+	case "rss", "atom":
+		return feedDecoder{}, nil
+	case "csv":
+		return csvDecoder{}, nil
+	default:
+		return nil, fmt.Errorf("unknown input format %q", format)
+	}
+}
+
+// categoriesFromPath derives a default category from a URL's first
+// path segment, used by sources (sitemap, feeds) that have no notion
+// of categories of their own. It mirrors the existing fallback to
+// "unknown_category" by returning nil when there's no path to use.
+func categoriesFromPath(rawURL string) []string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil
+	}
+	trimmed := strings.Trim(u.Path, "/")
+	if trimmed == "" {
+		return nil
+	}
+	return []string{strings.SplitN(trimmed, "/", 2)[0]}
+}
+
+// jsonlDecoder is the original format: one {"url":..,"categories":..}
+// object per line.
+type jsonlDecoder struct{}
+
+func (jsonlDecoder) Decode(r io.Reader, fn func(*item)) error {
+	br := bufio.NewReader(r)
+	for {
+		isPrefix := true
+		var line, part []byte
+		var err error
+
+		for isPrefix && err == nil {
+			part, isPrefix, err = br.ReadLine()
+			line = append(line, part...)
+		}
+
+		if len(line) > 0 {
+			i := &item{}
+			if uerr := json.Unmarshal(line, i); uerr != nil {
+				fmt.Fprintf(os.Stderr, "cannot unmarshal %s: %s\n", line, uerr)
+			} else {
+				fn(i)
+			}
+		}
+
+		if err != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// csvDecoder reads "url[,category...]" rows. A row with no category
+// columns falls back to categoriesFromPath, same as sitemap/feed input.
+type csvDecoder struct{}
+
+func (csvDecoder) Decode(r io.Reader, fn func(*item)) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(record) == 0 {
+			continue
+		}
+
+		i := &item{Url: strings.TrimSpace(record[0])}
+		if i.Url == "" {
+			continue
+		}
+		for _, c := range record[1:] {
+			if c = strings.TrimSpace(c); c != "" {
+				i.Categories = append(i.Categories, c)
+			}
+		}
+		if len(i.Categories) == 0 {
+			i.Categories = categoriesFromPath(i.Url)
+		}
+		fn(i)
+	}
+}
+
+// sitemapDecoder expands a <sitemapindex> recursively and emits one
+// item per <url> in a <urlset>, including gzipped sitemaps.
+type sitemapDecoder struct{}
+
+func (sitemapDecoder) Decode(r io.Reader, fn func(*item)) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return decodeSitemap(data, fn)
+}
+
+func decodeSitemap(data []byte, fn func(*item)) error {
+	if len(data) > 2 && data[0] == 0x1f && data[1] == 0x8b {
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		if data, err = ioutil.ReadAll(gz); err != nil {
+			return err
+		}
+	}
+
+	root, err := xmlRootElement(data)
+	if err != nil {
+		return err
+	}
+
+	switch root {
+	case "sitemapindex":
+		var index struct {
+			Sitemaps []struct {
+				Loc string `xml:"loc"`
+			} `xml:"sitemap"`
+		}
+		if err := xml.Unmarshal(data, &index); err != nil {
+			return err
+		}
+		for _, sm := range index.Sitemaps {
+			nested, err := openInput(sm.Loc)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "cannot fetch nested sitemap %s: %s\n", sm.Loc, err)
+				continue
+			}
+			nestedData, err := ioutil.ReadAll(nested)
+			nested.Close()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "cannot read nested sitemap %s: %s\n", sm.Loc, err)
+				continue
+			}
+			if err := decodeSitemap(nestedData, fn); err != nil {
+				fmt.Fprintf(os.Stderr, "cannot parse nested sitemap %s: %s\n", sm.Loc, err)
+			}
+		}
+	case "urlset":
+		var set struct {
+			URLs []struct {
+				Loc string `xml:"loc"`
+			} `xml:"url"`
+		}
+		if err := xml.Unmarshal(data, &set); err != nil {
+			return err
+		}
+		for _, u := range set.URLs {
+			fn(&item{Url: u.Loc, Categories: categoriesFromPath(u.Loc)})
+		}
+	default:
+		return fmt.Errorf("unrecognized sitemap root element %q", root)
+	}
+	return nil
+}
+
+// feedDecoder handles RSS (<rss><channel><item><link>) and Atom
+// (<feed><entry><link href>) seed lists.
+type feedDecoder struct{}
+
+func (feedDecoder) Decode(r io.Reader, fn func(*item)) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	root, err := xmlRootElement(data)
+	if err != nil {
+		return err
+	}
+
+	switch root {
+	case "rss":
+		var feed struct {
+			Channel struct {
+				Items []struct {
+					Link string `xml:"link"`
+				} `xml:"item"`
+			} `xml:"channel"`
+		}
+		if err := xml.Unmarshal(data, &feed); err != nil {
+			return err
+		}
+		for _, it := range feed.Channel.Items {
+			fn(&item{Url: it.Link, Categories: categoriesFromPath(it.Link)})
+		}
+	case "feed":
+		var feed struct {
+			Entries []struct {
+				Links []struct {
+					Href string `xml:"href,attr"`
+					Rel  string `xml:"rel,attr"`
+				} `xml:"link"`
+			} `xml:"entry"`
+		}
+		if err := xml.Unmarshal(data, &feed); err != nil {
+			return err
+		}
+		for _, entry := range feed.Entries {
+			link := atomEntryLink(entry.Links)
+			if link == "" {
+				continue
+			}
+			fn(&item{Url: link, Categories: categoriesFromPath(link)})
+		}
+	default:
+		return fmt.Errorf("unrecognized feed root element %q", root)
+	}
+	return nil
+}
+
+func atomEntryLink(links []struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+}) string {
+	for _, l := range links {
+		if l.Rel == "" || l.Rel == "alternate" {
+			return l.Href
+		}
+	}
+	if len(links) > 0 {
+		return links[0].Href
+	}
+	return ""
+}
+
+// xmlRootElement returns the local name of data's top-level element,
+// used to tell a sitemap index from a urlset, or RSS from Atom,
+// without hand-rolling two near-identical unmarshal attempts.
+func xmlRootElement(data []byte) (string, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return "", err
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			return start.Name.Local, nil
+		}
+	}
+}
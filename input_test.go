@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCSVDecoder(t *testing.T) {
+	input := "http://example.com/a,news,sport\nhttp://example.com/b\n"
+
+	var got []*item
+	if err := (csvDecoder{}).Decode(bytes.NewReader([]byte(input)), func(i *item) { got = append(got, i) }); err != nil {
+		t.Fatalf("Decode: %s", err)
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("got %d items, want 2", len(got))
+	}
+	if got[0].Url != "http://example.com/a" || len(got[0].Categories) != 2 {
+		t.Fatalf("unexpected first item: %+v", got[0])
+	}
+	// No category columns: falls back to categoriesFromPath, which
+	// derives one from the URL's first path segment.
+	if got[1].Url != "http://example.com/b" || len(got[1].Categories) != 1 || got[1].Categories[0] != "b" {
+		t.Fatalf("unexpected second item: %+v", got[1])
+	}
+}
+
+func TestFeedDecoderRSS(t *testing.T) {
+	rss := `<rss><channel><item><link>http://example.com/rss-item</link></item></channel></rss>`
+
+	var got []*item
+	if err := (feedDecoder{}).Decode(bytes.NewReader([]byte(rss)), func(i *item) { got = append(got, i) }); err != nil {
+		t.Fatalf("Decode: %s", err)
+	}
+	if len(got) != 1 || got[0].Url != "http://example.com/rss-item" {
+		t.Fatalf("unexpected items: %+v", got)
+	}
+}
+
+func TestFeedDecoderAtom(t *testing.T) {
+	atom := `<feed><entry><link rel="alternate" href="http://example.com/atom-entry"/></entry></feed>`
+
+	var got []*item
+	if err := (feedDecoder{}).Decode(bytes.NewReader([]byte(atom)), func(i *item) { got = append(got, i) }); err != nil {
+		t.Fatalf("Decode: %s", err)
+	}
+	if len(got) != 1 || got[0].Url != "http://example.com/atom-entry" {
+		t.Fatalf("unexpected items: %+v", got)
+	}
+}
+
+// TestSitemapDecoderGzippedIndex exercises a gzipped <sitemapindex> that
+// points at a nested, also gzipped, <urlset>, which is how real sitemap
+// bundles are usually shipped.
+func TestSitemapDecoderGzippedIndex(t *testing.T) {
+	dir := t.TempDir()
+
+	urlset := `<urlset><url><loc>http://example.com/news/a</loc></url></urlset>`
+	nestedPath := filepath.Join(dir, "nested.xml.gz")
+	if err := os.WriteFile(nestedPath, gzipBytes(t, urlset), 0644); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+
+	index := `<sitemapindex><sitemap><loc>` + nestedPath + `</loc></sitemap></sitemapindex>`
+
+	var got []*item
+	if err := (sitemapDecoder{}).Decode(bytes.NewReader(gzipBytes(t, index)), func(i *item) { got = append(got, i) }); err != nil {
+		t.Fatalf("Decode: %s", err)
+	}
+
+	if len(got) != 1 || got[0].Url != "http://example.com/news/a" {
+		t.Fatalf("unexpected items: %+v", got)
+	}
+	if len(got[0].Categories) != 1 || got[0].Categories[0] != "news" {
+		t.Fatalf("unexpected categories: %+v", got[0].Categories)
+	}
+}
+
+func gzipBytes(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write([]byte(s)); err != nil {
+		t.Fatalf("gzip Write: %s", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("gzip Close: %s", err)
+	}
+	return buf.Bytes()
+}
@@ -3,55 +3,104 @@ package main
 import (
 	"bufio"
 	"encoding/json"
+	"flag"
 	"fmt"
-	"golang.org/x/net/html"
-	"net/http"
+	"io"
+	"io/ioutil"
 	"os"
-	"path"
 	"regexp"
 	"runtime"
-	"strings"
 	"sync"
 	"time"
 )
 
+// categoryFile streams rows straight to disk through a buffered writer
+// instead of accumulating them in memory, so a crawl of millions of
+// URLs doesn't grow the process without bound.
 type categoryFile struct {
-	m   sync.Mutex
-	raw strings.Builder
+	m sync.Mutex
+	f io.WriteCloser
+	w *bufio.Writer
 }
 
-func (f *categoryFile) add(link, title, description string) {
+// newCategoryFile opens a category's file in append mode: a resumed run
+// skips URLs already recorded in state.json, so it must add to a
+// category's existing rows rather than truncating them away. A crash
+// between a row being written and the next state.json autosave can
+// still produce a duplicate row on the next run, the same bounded
+// window state.save's doc comment already accepts for lost progress.
+// This assumes the output directory and its state.json stay paired; an
+// output directory reused with state.json removed will append onto
+// stale rows instead of starting clean.
+func newCategoryFile(out FileSystem, category string) (*categoryFile, error) {
+	f, err := out.OpenAppend(category + ".jsonl")
+	if err != nil {
+		return nil, err
+	}
+	return &categoryFile{f: f, w: bufio.NewWriter(f)}, nil
+}
+
+// add appends rec as a single JSON line. The extractor pipeline fills in
+// far more fields than the old 3-column TSV could hold, so each category
+// file is newline-delimited JSON instead.
+func (f *categoryFile) add(rec *Record) {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot marshal record for %s: %s\n", rec.Url, err)
+		return
+	}
+
 	f.m.Lock()
-	f.raw.WriteString(link)
-	f.raw.WriteString("\t")
-	f.raw.WriteString(title)
-	f.raw.WriteString("\t")
-	f.raw.WriteString(description)
-	f.raw.WriteString("\n")
+	f.w.Write(line)
+	f.w.WriteString("\n")
 	f.m.Unlock()
 }
 
+// close flushes the buffer, syncs to disk when the underlying writer
+// supports it, and closes the file.
+func (f *categoryFile) close() error {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if err := f.w.Flush(); err != nil {
+		return err
+	}
+	if syncer, ok := f.f.(interface{ Sync() error }); ok {
+		if err := syncer.Sync(); err != nil {
+			return err
+		}
+	}
+	return f.f.Close()
+}
+
 type categoryFilesMap struct {
 	sync.RWMutex
-	m map[string]*categoryFile
+	m   map[string]*categoryFile
+	out FileSystem
 }
 
-func (m *categoryFilesMap) get(category string) (*categoryFile, bool) {
+// getOrCreate returns the category's file, opening it the first time
+// the category is seen.
+func (m *categoryFilesMap) getOrCreate(category string) (*categoryFile, error) {
 	m.RLock()
 	f, ok := m.m[category]
 	m.RUnlock()
-	return f, ok
-}
+	if ok {
+		return f, nil
+	}
 
-func (m *categoryFilesMap) new(category string) *categoryFile {
 	m.Lock()
-	f, ok := m.m[category]
-	if !ok {
-		f = &categoryFile{}
-		m.m[category] = f
+	defer m.Unlock()
+	if f, ok := m.m[category]; ok {
+		return f, nil
 	}
-	m.Unlock()
-	return f
+
+	f, err := newCategoryFile(m.out, category)
+	if err != nil {
+		return nil, err
+	}
+	m.m[category] = f
+	return f, nil
 }
 
 type item struct {
@@ -59,153 +108,192 @@ type item struct {
 	Categories []string `json:"categories"`
 }
 
+var (
+	qps           = flag.Float64("qps", 0, "max requests per second per host (0 = unlimited)")
+	maxRetries    = flag.Int("max-retries", 3, "retries for transient fetch errors before giving up on a URL")
+	userAgent     = flag.String("user-agent", "sites_parsing_tool", "User-Agent header sent with every request")
+	respectRobots = flag.Bool("respect-robots", false, "skip URLs disallowed by robots.txt")
+	inputFormat   = flag.String("input-format", "", "input format: jsonl, sitemap, rss, atom or csv (default: guessed from the file extension)")
+	logFile       = flag.String("log-file", "", "write a JSON-lines event log to this file")
+	metricsAddr   = flag.String("metrics-addr", "", "serve Prometheus /metrics and /healthz on this address, e.g. :9090")
+)
+
 func main() {
-	if len(os.Args) < 3 {
-		fmt.Println("usage: parsing <input file> <output path>")
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 2 {
+		fmt.Println("usage: parsing [flags] <input file> <output path>")
+		flag.PrintDefaults()
 		os.Exit(1)
 	}
 
-	f, err := os.Open(os.Args[1])
+	f, err := openInput(args[0])
 	if err != nil {
 		fmt.Printf("cannot open the file: %s\n", err)
 		os.Exit(1)
 	}
 
-	if _, err := os.Stat(os.Args[2]); os.IsNotExist(err) {
-		fmt.Println("no such output path")
+	decoder, err := decoderFor(*inputFormat, args[0])
+	if err != nil {
+		fmt.Println(err)
 		os.Exit(1)
 	}
 
-	filesMap := &categoryFilesMap{m:make(map[string]*categoryFile)}
-	ch := make(chan []byte, 1)
-	var wg sync.WaitGroup
-	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
-		wg.Add(1)
-		go worker(&wg, ch, filesMap)
+	out, err := openOutput(args[1])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
 
-	r := bufio.NewReader(f)
-
-	for {
-		isPrefix := true
-		var line, partLine []byte
-		var err error
+	fetcher := newFetcher(*qps, *maxRetries, *userAgent, *respectRobots)
 
-		for isPrefix && err == nil {
-			partLine, isPrefix, err = r.ReadLine()
-			line = append(line, partLine...)
-		}
+	reporters := multiReporter{stdoutReporter{}}
+	if *logFile != "" {
+		jr, closer, err := newJSONReporter(*logFile)
 		if err != nil {
-			break
+			fmt.Println(err)
+			os.Exit(1)
 		}
+		defer closer.Close()
+		reporters = append(reporters, jr)
+	}
+	if *metricsAddr != "" {
+		mr := newMetricsReporter()
+		mr.serve(*metricsAddr)
+		reporters = append(reporters, mr)
+	}
+	var reporter Reporter = reporters
 
-		if len(line) == 0 {
-			continue
+	filesMap := &categoryFilesMap{m: make(map[string]*categoryFile), out: out}
+
+	// tarGzFS is write-only, so state.json can never be read back out of
+	// it: resuming a .tar.gz output would silently reprocess everything
+	// anyway, and writing state.json to it on every tick would only pile
+	// up duplicate, unreadable tar entries. Skip resumable state entirely
+	// for that output kind instead.
+	_, unresumable := out.(*tarGzFS)
+	resumable := !unresumable
+	state := newRunState()
+	if resumable {
+		state = loadRunState(out)
+	} else {
+		fmt.Fprintln(os.Stderr, "warning: resumable state is not supported for this output; every run will refetch all URLs")
+	}
+
+	stopSaving := make(chan struct{})
+	saveDone := make(chan struct{})
+	go func() {
+		defer close(saveDone)
+		if !resumable {
+			<-stopSaving
+			return
 		}
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				state.save(out)
+			case <-stopSaving:
+				return
+			}
+		}
+	}()
+
+	ch := make(chan *item, 1)
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		wg.Add(1)
+		go worker(&wg, ch, filesMap, state, fetcher, reporter)
+	}
 
-		ch <- line
+	if err := decoder.Decode(f, func(i *item) {
+		ch <- i
+		reporter.QueueDepth(len(ch))
+	}); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot decode input: %s\n", err)
 	}
 	close(ch)
 	f.Close()
 
 	wg.Wait()
+	close(stopSaving)
+	<-saveDone
 
-	for name, cf := range filesMap.m {
-		f, err := os.Create(path.Join(os.Args[2], name + ".tsv"))
-		if err != nil {
-			fmt.Printf("cannot open file for %s category: %s\n", name, err)
-			os.Exit(1)
+	for _, cf := range filesMap.m {
+		if err := cf.close(); err != nil {
+			fmt.Printf("cannot finalize category file: %s\n", err)
+		}
+	}
+
+	if resumable {
+		if err := state.save(out); err != nil {
+			fmt.Printf("cannot save state: %s\n", err)
 		}
+	}
 
-		f.WriteString(cf.raw.String())
-		f.Close()
+	if closer, ok := out.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			fmt.Printf("cannot finalize output: %s\n", err)
+			os.Exit(1)
+		}
 	}
 }
 
 var regexSpace = regexp.MustCompile(`\s+`)
 
-func worker(wg *sync.WaitGroup, ch <-chan []byte,  filesMap *categoryFilesMap) {
-	cli := http.Client{Timeout: 15 * time.Second}
-
-	for line := range ch {
-		i := &item{}
-		if err := json.Unmarshal(line, i); err != nil {
-			fmt.Fprintf(os.Stderr, "cannot unmarshal %s: %s\n", line, err)
+func worker(wg *sync.WaitGroup, ch <-chan *item, filesMap *categoryFilesMap, state *runState, fetcher *Fetcher, reporter Reporter) {
+	for i := range ch {
+		if state.isDone(i.Url) {
 			continue
 		}
 
-		resp, err := cli.Get(i.Url)
+		start := time.Now()
+		resp, err := fetcher.Get(i.Url)
+		latency := time.Since(start)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "cannot fetch %s: %s\n", i.Url, err)
+			reporter.FetchFailed(i.Url, err)
 			continue
 		}
 		if resp.StatusCode >= 300 {
-			fmt.Fprintf(os.Stderr, "%s returned status %d\n", i.Url, resp.StatusCode)
+			resp.Body.Close()
+			reporter.Fetched(i.Url, resp.StatusCode, latency, 0)
 			continue
 		}
 
-		tokens := html.NewTokenizer(resp.Body)
-		title := ""
-		description := ""
-		titleFind := false
-		descriptionFind := false
-		for {
-			tt := tokens.Next()
-			err := false
-			switch tt {
-			case html.ErrorToken:
-				err = true
-			case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
-				tkn := tokens.Token()
-				switch tkn.Data {
-				case "title":
-					if tt == html.StartTagToken {
-						titleText := tokens.Next()
-						if titleText == html.TextToken {
-							title = strings.TrimSpace(regexSpace.ReplaceAllString(tokens.Token().Data, " "))
-						}
-						titleFind = true
-					}
-				case "meta":
-					for _, attr := range tkn.Attr {
-						if attr.Key == "name" {
-							if strings.ToLower(attr.Val) == "description" {
-								for _, attr := range tkn.Attr {
-									if attr.Key == "content" {
-										description = strings.TrimSpace(regexSpace.ReplaceAllString(attr.Val, " "))
-										break
-									}
-								}
-								descriptionFind = true
-								break
-							}
-						}
-					}
-				}
-			}
-			if (titleFind && descriptionFind) || err {
-				break
-			}
-		}
+		body, err := ioutil.ReadAll(resp.Body)
 		resp.Body.Close()
+		if err != nil {
+			reporter.ParseError(i.Url, "body_read")
+			continue
+		}
+		reporter.Fetched(i.Url, resp.StatusCode, latency, len(body))
 
-		if len(i.Categories) > 0 {
-			for _, c := range i.Categories {
-				f, ok := filesMap.get(c)
-				if !ok {
-					f = filesMap.new(c)
-				}
-				f.add(i.Url, title, description)
-			}
-		} else {
-			f, ok := filesMap.get("unknown_category")
-			if !ok {
-				f = filesMap.new("unknown_category")
+		rec := runExtractors(&ExtractionContext{Url: i.Url, Body: body})
+		rec.Categories = i.Categories
+
+		categories := i.Categories
+		if len(categories) == 0 {
+			categories = []string{"unknown_category"}
+		}
+		wroteAny := false
+		for _, c := range categories {
+			f, err := filesMap.getOrCreate(c)
+			if err != nil {
+				reporter.ParseError(i.Url, "category_open")
+				continue
 			}
-			f.add(i.Url, title, description)
+			f.add(rec)
+			reporter.CategoryWrite(c)
+			wroteAny = true
 		}
 
-		fmt.Printf("handled %s\n", i.Url)
+		// Only mark the URL done once something was actually written for
+		// it; otherwise a resumed run would skip it forever despite never
+		// having recorded it anywhere.
+		if wroteAny {
+			state.markDone(i.Url)
+		}
 	}
 	wg.Done()
 }
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Reporter replaces the ad-hoc fmt.Printf/Fprintf calls worker used to
+// make directly, so progress can be sent to a human terminal, a
+// JSON-lines event log, a Prometheus endpoint, or any combination of
+// the three.
+type Reporter interface {
+	// Fetched records a completed HTTP fetch, successful or not.
+	Fetched(url string, status int, latency time.Duration, bytes int)
+	// FetchFailed records a fetch that never produced an HTTP response
+	// (network error, timeout, robots.txt disallow).
+	FetchFailed(url string, err error)
+	// ParseError records a failure to turn a fetched body into a Record.
+	ParseError(url, reason string)
+	// QueueDepth reports how many items are currently queued for a worker.
+	QueueDepth(n int)
+	// CategoryWrite records one row written to a category file.
+	CategoryWrite(category string)
+}
+
+// multiReporter fans every call out to each of its members.
+type multiReporter []Reporter
+
+func (m multiReporter) Fetched(url string, status int, latency time.Duration, bytes int) {
+	for _, r := range m {
+		r.Fetched(url, status, latency, bytes)
+	}
+}
+
+func (m multiReporter) FetchFailed(url string, err error) {
+	for _, r := range m {
+		r.FetchFailed(url, err)
+	}
+}
+
+func (m multiReporter) ParseError(url, reason string) {
+	for _, r := range m {
+		r.ParseError(url, reason)
+	}
+}
+
+func (m multiReporter) QueueDepth(n int) {
+	for _, r := range m {
+		r.QueueDepth(n)
+	}
+}
+
+func (m multiReporter) CategoryWrite(category string) {
+	for _, r := range m {
+		r.CategoryWrite(category)
+	}
+}
+
+// stdoutReporter preserves the tool's original terminal output.
+type stdoutReporter struct{}
+
+func (stdoutReporter) Fetched(url string, status int, latency time.Duration, bytes int) {
+	fmt.Printf("handled %s\n", url)
+}
+
+func (stdoutReporter) FetchFailed(url string, err error) {
+	fmt.Fprintf(os.Stderr, "cannot fetch %s: %s\n", url, err)
+}
+
+func (stdoutReporter) ParseError(url, reason string) {
+	fmt.Fprintf(os.Stderr, "%s: %s\n", url, reason)
+}
+
+func (stdoutReporter) QueueDepth(n int) {}
+
+func (stdoutReporter) CategoryWrite(category string) {}
+
+// jsonReporter writes one JSON object per event to an underlying
+// writer, e.g. a -log-file, for offline analysis or shipping to a log
+// pipeline.
+type jsonReporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func newJSONReporter(path string) (*jsonReporter, io.Closer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &jsonReporter{w: f}, f, nil
+}
+
+func (r *jsonReporter) emit(event string, fields map[string]interface{}) {
+	fields["ts"] = time.Now().Format(time.RFC3339Nano)
+	fields["event"] = event
+
+	line, err := json.Marshal(fields)
+	if err != nil {
+		return
+	}
+
+	r.mu.Lock()
+	r.w.Write(line)
+	r.w.Write([]byte("\n"))
+	r.mu.Unlock()
+}
+
+func (r *jsonReporter) Fetched(url string, status int, latency time.Duration, bytes int) {
+	r.emit("fetched", map[string]interface{}{
+		"url":        url,
+		"status":     status,
+		"latency_ms": latency.Milliseconds(),
+		"bytes":      bytes,
+	})
+}
+
+func (r *jsonReporter) FetchFailed(url string, err error) {
+	r.emit("fetch_failed", map[string]interface{}{"url": url, "error": err.Error()})
+}
+
+func (r *jsonReporter) ParseError(url, reason string) {
+	r.emit("parse_error", map[string]interface{}{"url": url, "reason": reason})
+}
+
+func (r *jsonReporter) QueueDepth(n int) {
+	r.emit("queue_depth", map[string]interface{}{"depth": n})
+}
+
+func (r *jsonReporter) CategoryWrite(category string) {
+	r.emit("category_write", map[string]interface{}{"category": category})
+}
+
+// histogram is a minimal Prometheus-style cumulative histogram: each
+// bucket counts every observation less than or equal to its upper
+// bound, which is exactly the "le" semantics the exposition format
+// expects.
+type histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []int64
+	sum     float64
+	count   int64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]int64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sum += v
+	h.count++
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+}
+
+func (h *histogram) writeTo(w io.Writer, name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, b := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", name, b, h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %g\n", name, h.sum)
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
+
+// metricsReporter keeps in-memory counters and a histogram, exposed
+// over HTTP in the Prometheus text exposition format plus a /healthz,
+// so a long-lived crawl can be wired into existing observability
+// stacks without a separate sidecar.
+type metricsReporter struct {
+	mu              sync.Mutex
+	urlsProcessed   map[string]int64
+	parseErrors     map[string]int64
+	queueDepth      int64
+	categoriesTotal int64
+	fetchDuration   *histogram
+}
+
+func newMetricsReporter() *metricsReporter {
+	return &metricsReporter{
+		urlsProcessed: make(map[string]int64),
+		parseErrors:   make(map[string]int64),
+		fetchDuration: newHistogram([]float64{0.1, 0.25, 0.5, 1, 2, 5, 10}),
+	}
+}
+
+func (m *metricsReporter) Fetched(url string, status int, latency time.Duration, bytes int) {
+	m.mu.Lock()
+	m.urlsProcessed[fmt.Sprintf("%d", status)]++
+	m.mu.Unlock()
+	m.fetchDuration.observe(latency.Seconds())
+}
+
+func (m *metricsReporter) FetchFailed(url string, err error) {
+	m.mu.Lock()
+	m.urlsProcessed["error"]++
+	m.mu.Unlock()
+}
+
+func (m *metricsReporter) ParseError(url, reason string) {
+	m.mu.Lock()
+	m.parseErrors[reason]++
+	m.mu.Unlock()
+}
+
+func (m *metricsReporter) QueueDepth(n int) {
+	atomic.StoreInt64(&m.queueDepth, int64(n))
+}
+
+func (m *metricsReporter) CategoryWrite(category string) {
+	atomic.AddInt64(&m.categoriesTotal, 1)
+}
+
+func (m *metricsReporter) writeTo(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP urls_processed_total Total URLs processed, by final status.")
+	fmt.Fprintln(w, "# TYPE urls_processed_total counter")
+	for status, n := range m.urlsProcessed {
+		fmt.Fprintf(w, "urls_processed_total{status=\"%s\"} %d\n", status, n)
+	}
+
+	fmt.Fprintln(w, "# HELP fetch_duration_seconds Time spent fetching a URL.")
+	fmt.Fprintln(w, "# TYPE fetch_duration_seconds histogram")
+	m.fetchDuration.writeTo(w, "fetch_duration_seconds")
+
+	fmt.Fprintln(w, "# HELP parse_errors_total Total content extraction failures, by reason.")
+	fmt.Fprintln(w, "# TYPE parse_errors_total counter")
+	for reason, n := range m.parseErrors {
+		fmt.Fprintf(w, "parse_errors_total{reason=\"%s\"} %d\n", reason, n)
+	}
+
+	fmt.Fprintln(w, "# HELP queue_depth Items currently queued for a worker.")
+	fmt.Fprintln(w, "# TYPE queue_depth gauge")
+	fmt.Fprintf(w, "queue_depth %d\n", atomic.LoadInt64(&m.queueDepth))
+
+	fmt.Fprintln(w, "# HELP categories_total Total rows written across all category files.")
+	fmt.Fprintln(w, "# TYPE categories_total counter")
+	fmt.Fprintf(w, "categories_total %d\n", atomic.LoadInt64(&m.categoriesTotal))
+}
+
+// serve starts the /metrics and /healthz HTTP server in the
+// background. A failure here (e.g. the address is already in use) is
+// reported but doesn't stop the crawl itself.
+func (m *metricsReporter) serve(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		m.writeTo(w)
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	})
+
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			fmt.Fprintf(os.Stderr, "metrics server stopped: %s\n", err)
+		}
+	}()
+}
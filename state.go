@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+)
+
+// stateFileName is the sidecar written alongside the category files
+// that records which URLs have already been processed, so a killed and
+// restarted run doesn't refetch or duplicate them.
+const stateFileName = "state.json"
+
+// runState tracks processed URLs by hash rather than by raw URL, both
+// to keep the sidecar small and because the hash is what gets checked
+// on every line of input.
+type runState struct {
+	mu   sync.Mutex
+	Done map[string]bool `json:"done"`
+}
+
+func newRunState() *runState {
+	return &runState{Done: make(map[string]bool)}
+}
+
+// loadRunState reads state.json out of out, if present. A missing or
+// unreadable sidecar just means this is the first run against this
+// output.
+func loadRunState(out FileSystem) *runState {
+	s := newRunState()
+	f, err := out.Open(stateFileName)
+	if err != nil {
+		return s
+	}
+	defer f.Close()
+	json.NewDecoder(f).Decode(s)
+	return s
+}
+
+func urlHash(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *runState) isDone(url string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Done[urlHash(url)]
+}
+
+func (s *runState) markDone(url string) {
+	s.mu.Lock()
+	s.Done[urlHash(url)] = true
+	s.mu.Unlock()
+}
+
+// save writes the current state out in full. It's called periodically
+// during a run and once more on shutdown, so an interrupted run never
+// loses more than the last save interval's worth of progress.
+func (s *runState) save(out FileSystem) error {
+	s.mu.Lock()
+	data, err := json.Marshal(s)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	f, err := out.Create(stateFileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(data)
+	return err
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+// FileSystem abstracts the handful of operations main needs from its
+// input and output arguments, so they can point at a local path, a
+// bundled archive, a remote URL or (in tests) nothing on disk at all.
+type FileSystem interface {
+	Open(name string) (io.ReadCloser, error)
+	Create(name string) (io.WriteCloser, error)
+	// OpenAppend opens name for writing, appending to any existing
+	// content instead of truncating it, creating it if it doesn't
+	// exist yet.
+	OpenAppend(name string) (io.WriteCloser, error)
+}
+
+// openInput resolves an input argument to a single readable stream, by
+// first resolving it to a FileSystem and a name to Open within it —
+// the same abstraction openOutput uses, so the whole pipeline (input
+// and output alike) can be driven through FileSystem in tests.
+func openInput(arg string) (io.ReadCloser, error) {
+	fs, name, err := inputFileSystem(arg)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Open(name)
+}
+
+// inputFileSystem picks the FileSystem that should serve arg and the
+// name to Open within it. "http://" and "https://" arguments are
+// served by httpFS, "zip://archive.zip[!member]" by zipFS (the first
+// member when none is given), and anything else by a localFS rooted
+// at arg's directory.
+func inputFileSystem(arg string) (FileSystem, string, error) {
+	switch {
+	case strings.HasPrefix(arg, "http://"), strings.HasPrefix(arg, "https://"):
+		return httpFS{}, arg, nil
+	case strings.HasPrefix(arg, "zip://"):
+		spec := strings.TrimPrefix(arg, "zip://")
+		archivePath, member := spec, ""
+		if idx := strings.Index(spec, "!"); idx >= 0 {
+			archivePath, member = spec[:idx], spec[idx+1:]
+		}
+		zfs, err := newZipFS(archivePath)
+		if err != nil {
+			return nil, "", err
+		}
+		if member == "" {
+			member, err = zfs.firstName()
+			if err != nil {
+				return nil, "", err
+			}
+		}
+		return zfs, member, nil
+	default:
+		dir, file := path.Split(arg)
+		if dir == "" {
+			dir = "."
+		}
+		return localFS{root: dir}, file, nil
+	}
+}
+
+// httpFS serves a single input over HTTP: Open treats name as the full
+// URL to fetch, ignoring any notion of a root.
+type httpFS struct{}
+
+func (httpFS) Open(name string) (io.ReadCloser, error) {
+	resp, err := http.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s returned status %d", name, resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+func (httpFS) Create(name string) (io.WriteCloser, error) {
+	return nil, fmt.Errorf("http input is read-only")
+}
+
+func (httpFS) OpenAppend(name string) (io.WriteCloser, error) {
+	return nil, fmt.Errorf("http input is read-only")
+}
+
+// openOutput resolves an output argument to a FileSystem that category
+// files are written through. "path.tar.gz" streams a gzip-compressed
+// tar archive; anything else is treated as a local directory.
+func openOutput(arg string) (FileSystem, error) {
+	switch {
+	case strings.HasSuffix(arg, ".tar.gz"):
+		return newTarGzFS(arg)
+	default:
+		if _, err := os.Stat(arg); os.IsNotExist(err) {
+			return nil, fmt.Errorf("no such output path")
+		}
+		return localFS{root: arg}, nil
+	}
+}
+
+// localFS rooted at a directory, the original behaviour.
+type localFS struct {
+	root string
+}
+
+func (fs localFS) Open(name string) (io.ReadCloser, error) {
+	return os.Open(path.Join(fs.root, name))
+}
+
+func (fs localFS) Create(name string) (io.WriteCloser, error) {
+	return os.Create(path.Join(fs.root, name))
+}
+
+func (fs localFS) OpenAppend(name string) (io.WriteCloser, error) {
+	return os.OpenFile(path.Join(fs.root, name), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+}
+
+// mapFS is an in-memory FileSystem, primarily useful for testing the
+// pipeline without touching disk.
+type mapFS struct {
+	files map[string][]byte
+}
+
+func newMapFS(files map[string]string) *mapFS {
+	fs := &mapFS{files: make(map[string][]byte, len(files))}
+	for name, content := range files {
+		fs.files[name] = []byte(content)
+	}
+	return fs
+}
+
+type mapFSFile struct {
+	*strings.Reader
+}
+
+func (mapFSFile) Close() error { return nil }
+
+func (fs *mapFS) Open(name string) (io.ReadCloser, error) {
+	content, ok := fs.files[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return mapFSFile{strings.NewReader(string(content))}, nil
+}
+
+type mapFSWriter struct {
+	fs   *mapFS
+	name string
+	buf  strings.Builder
+}
+
+func (w *mapFSWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *mapFSWriter) Close() error {
+	w.fs.files[w.name] = []byte(w.buf.String())
+	return nil
+}
+
+func (fs *mapFS) Create(name string) (io.WriteCloser, error) {
+	return &mapFSWriter{fs: fs, name: name}, nil
+}
+
+func (fs *mapFS) OpenAppend(name string) (io.WriteCloser, error) {
+	w := &mapFSWriter{fs: fs, name: name}
+	w.buf.Write(fs.files[name])
+	return w, nil
+}
+
+// zipFS reads members out of a local zip archive, e.g. for a bundled
+// list of URLs.
+type zipFS struct {
+	path string
+	r    *zip.ReadCloser
+}
+
+func newZipFS(archivePath string) (*zipFS, error) {
+	r, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	return &zipFS{path: archivePath, r: r}, nil
+}
+
+// firstName returns the name of the archive's first member, used when
+// no "!member" was given.
+func (z *zipFS) firstName() (string, error) {
+	if len(z.r.File) == 0 {
+		return "", fmt.Errorf("zip archive %s is empty", z.path)
+	}
+	return z.r.File[0].Name, nil
+}
+
+func (z *zipFS) Open(name string) (io.ReadCloser, error) {
+	for _, zf := range z.r.File {
+		if zf.Name != name {
+			continue
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return nil, err
+		}
+		return zipEntry{ReadCloser: rc, archive: z.r}, nil
+	}
+	return nil, fmt.Errorf("no such member %s in %s", name, z.path)
+}
+
+func (z *zipFS) Create(name string) (io.WriteCloser, error) {
+	return nil, fmt.Errorf("zip input is read-only")
+}
+
+func (z *zipFS) OpenAppend(name string) (io.WriteCloser, error) {
+	return nil, fmt.Errorf("zip input is read-only")
+}
+
+// zipEntry closes both the file entry and the archive itself.
+type zipEntry struct {
+	io.ReadCloser
+	archive *zip.ReadCloser
+}
+
+func (z zipEntry) Close() error {
+	z.ReadCloser.Close()
+	return z.archive.Close()
+}
+
+// tarGzFS streams category files straight into a gzip-compressed tar
+// archive as they're created, so the output can be a single
+// ".tar.gz" instead of a directory of loose files. Entries must be
+// closed before the next one is created, and the archive must be
+// finalized by closing the FileSystem itself via tarGzFS.Close.
+type tarGzFS struct {
+	m  sync.Mutex
+	f  *os.File
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func newTarGzFS(name string) (*tarGzFS, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	gz := gzip.NewWriter(f)
+	return &tarGzFS{f: f, gz: gz, tw: tar.NewWriter(gz)}, nil
+}
+
+func (fs *tarGzFS) Open(name string) (io.ReadCloser, error) {
+	return nil, fmt.Errorf("tar.gz output is write-only")
+}
+
+func (fs *tarGzFS) Create(name string) (io.WriteCloser, error) {
+	return &tarGzEntry{fs: fs, name: name}, nil
+}
+
+// OpenAppend is equivalent to Create here: a .tar.gz output is always a
+// brand new archive, so there's never existing content for a member
+// name to append to.
+func (fs *tarGzFS) OpenAppend(name string) (io.WriteCloser, error) {
+	return fs.Create(name)
+}
+
+func (fs *tarGzFS) Close() error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	if err := fs.tw.Close(); err != nil {
+		return err
+	}
+	if err := fs.gz.Close(); err != nil {
+		return err
+	}
+	return fs.f.Close()
+}
+
+// tarGzEntry buffers one member's content so its size is known before
+// the tar header is written, then writes the header and body on Close.
+type tarGzEntry struct {
+	fs   *tarGzFS
+	name string
+	buf  strings.Builder
+}
+
+func (e *tarGzEntry) Write(p []byte) (int, error) {
+	return e.buf.Write(p)
+}
+
+func (e *tarGzEntry) Close() error {
+	e.fs.m.Lock()
+	defer e.fs.m.Unlock()
+
+	content := e.buf.String()
+	if err := e.fs.tw.WriteHeader(&tar.Header{
+		Name: e.name,
+		Size: int64(len(content)),
+		Mode: 0644,
+	}); err != nil {
+		return err
+	}
+	_, err := e.fs.tw.Write([]byte(content))
+	return err
+}